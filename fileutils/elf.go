@@ -0,0 +1,230 @@
+// Sandfly filescan ELF section-aware entropy analysis
+package fileutils
+
+/*
+A single whole-file entropy number can miss a packed or encrypted payload that only occupies part
+of an ELF binary: a small decompression stub plus a large compressed/encrypted .data section
+averages out to a moderate entropy that clears neither a "definitely packed" nor a "definitely
+clean" threshold. This file breaks an ELF down by section, computing entropy per section via
+debug/elf, and flags a handful of structural signatures common to packers (UPX's magic strings,
+overlapping PT_LOAD segments, a stripped section table next to a non-empty PT_LOAD segment). It
+also computes entropy over any PT_LOAD segment bytes that aren't covered by a named section at
+all, since a packer that strips the section table otherwise leaves its unpacking target completely
+unmeasured.
+*/
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	// constUPXMagic1/2 are the ASCII markers UPX embeds in packed ELF binaries, usually inside the
+	// first PT_LOAD segment ahead of the real section table (which UPX strips).
+	constUPXMagic1 = "UPX!"
+	constUPXMagic2 = "UPX0"
+)
+
+// SectionReport describes the entropy of one ELF section.
+type SectionReport struct {
+	Name    string
+	Size    uint64
+	Entropy float64
+}
+
+// ELFAnalysis is the result of analyzing an ELF file's sections and segments for packer signatures.
+type ELFAnalysis struct {
+	Sections []SectionReport
+	// PackerIndicators lists the structural signatures detected, e.g. "upx-signature",
+	// "overlapping-segments", "stripped-sections-large-segment". Empty means none were found.
+	PackerIndicators []string
+}
+
+// AnalyzeELFSections opens the ELF file at path and computes per-section entropy plus packer
+// structural indicators. It returns an error if path isn't a readable ELF file.
+func AnalyzeELFSections(path string) (analysis ELFAnalysis, err error) {
+	raw, err := os.Open(path)
+	if err != nil {
+		return analysis, fmt.Errorf("couldn't open path (%s): %v", path, err)
+	}
+	defer raw.Close()
+
+	f, err := elf.NewFile(raw)
+	if err != nil {
+		return analysis, fmt.Errorf("couldn't open path (%s) as ELF: %v", path, err)
+	}
+	defer f.Close()
+
+	var covered []byteRange
+	for _, sec := range f.Sections {
+		// SHT_NOBITS sections (e.g. .bss) occupy no file bytes; there's nothing to read or score.
+		if sec.Type == elf.SHT_NOBITS || sec.Size == 0 {
+			continue
+		}
+
+		data, err := sec.Data()
+		if err != nil {
+			continue
+		}
+
+		analysis.Sections = append(analysis.Sections, SectionReport{
+			Name:    sec.Name,
+			Size:    sec.Size,
+			Entropy: entropyOfBytes(data),
+		})
+		covered = append(covered, byteRange{start: sec.Offset, end: sec.Offset + sec.Size})
+	}
+
+	analysis.Sections = append(analysis.Sections, segmentGapSections(raw, f, covered)...)
+	analysis.PackerIndicators = detectPackerIndicators(f)
+
+	return analysis, nil
+}
+
+// byteRange is a half-open [start, end) range of file offsets.
+type byteRange struct {
+	start, end uint64
+}
+
+// segmentGapSections computes entropy over the bytes of each PT_LOAD segment that aren't covered
+// by any named section. This is the one place a packer that strips the section table leaves a
+// measurable trace: the stub's decompression target has no section pointing at it at all, so
+// per-section entropy alone never sees it.
+func segmentGapSections(raw io.ReaderAt, f *elf.File, covered []byteRange) []SectionReport {
+	var reports []SectionReport
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_LOAD || p.Filesz == 0 {
+			continue
+		}
+
+		for _, gap := range subtractRanges(byteRange{p.Off, p.Off + p.Filesz}, covered) {
+			size := gap.end - gap.start
+			if size == 0 {
+				continue
+			}
+
+			data := make([]byte, size)
+			if _, err := raw.ReadAt(data, int64(gap.start)); err != nil && err != io.EOF {
+				continue
+			}
+
+			reports = append(reports, SectionReport{
+				Name:    fmt.Sprintf("segment-gap:%#x-%#x", gap.start, gap.end),
+				Size:    size,
+				Entropy: entropyOfBytes(data),
+			})
+		}
+	}
+	return reports
+}
+
+// subtractRanges returns the portions of seg not covered by any range in covered. covered need not
+// be sorted or non-overlapping.
+func subtractRanges(seg byteRange, covered []byteRange) []byteRange {
+	sorted := make([]byteRange, len(covered))
+	copy(sorted, covered)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].start < sorted[j].start })
+
+	var gaps []byteRange
+	cursor := seg.start
+	for _, c := range sorted {
+		if c.end <= seg.start || c.start >= seg.end {
+			continue
+		}
+		cStart, cEnd := c.start, c.end
+		if cStart < seg.start {
+			cStart = seg.start
+		}
+		if cEnd > seg.end {
+			cEnd = seg.end
+		}
+		if cStart > cursor {
+			gaps = append(gaps, byteRange{cursor, cStart})
+		}
+		if cEnd > cursor {
+			cursor = cEnd
+		}
+	}
+	if cursor < seg.end {
+		gaps = append(gaps, byteRange{cursor, seg.end})
+	}
+	return gaps
+}
+
+// detectPackerIndicators looks for a handful of structural signatures common to ELF packers: UPX's
+// magic strings in a PT_LOAD segment, PT_LOAD segments that overlap in file offset (legitimate
+// linkers never produce this), and a stripped section table paired with a PT_LOAD segment that has
+// file contents (the shape of a single compressed/encrypted blob unpacked at runtime). The size of
+// that segment is not compared against any threshold, and the number of PT_LOAD segments is not
+// checked.
+func detectPackerIndicators(f *elf.File) []string {
+	var indicators []string
+
+	var loads []elf.ProgHeader
+	var maxLoadSize uint64
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_LOAD {
+			continue
+		}
+		loads = append(loads, p.ProgHeader)
+		if p.Filesz > maxLoadSize {
+			maxLoadSize = p.Filesz
+		}
+
+		if hasUPXMagic(p) {
+			indicators = append(indicators, "upx-signature")
+		}
+	}
+
+	if rangesOverlap(loads) {
+		indicators = append(indicators, "overlapping-segments")
+	}
+
+	// A normal ELF has more than just the null section; UPX and similar packers strip the section
+	// table down to nothing (or near-nothing) while leaving one huge PT_LOAD segment to unpack. This
+	// fires whenever any PT_LOAD segment has file contents; its size is not checked.
+	if len(f.Sections) <= 1 && maxLoadSize > 0 {
+		indicators = append(indicators, "stripped-sections-large-segment")
+	}
+
+	return indicators
+}
+
+// hasUPXMagic reports whether a PT_LOAD segment's file contents contain one of UPX's ASCII magic
+// strings.
+func hasUPXMagic(p *elf.Prog) bool {
+	data, err := io.ReadAll(p.Open())
+	if err != nil {
+		return false
+	}
+	return bytes.Contains(data, []byte(constUPXMagic1)) || bytes.Contains(data, []byte(constUPXMagic2))
+}
+
+// rangesOverlap reports whether any two PT_LOAD segments overlap in file offset, which a
+// well-formed ELF produced by a normal linker never does.
+func rangesOverlap(loads []elf.ProgHeader) bool {
+	for i := 0; i < len(loads); i++ {
+		for j := i + 1; j < len(loads); j++ {
+			aStart, aEnd := loads[i].Off, loads[i].Off+loads[i].Filesz
+			bStart, bEnd := loads[j].Off, loads[j].Off+loads[j].Filesz
+			if aStart < bEnd && bStart < aEnd {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// entropyOfBytes computes the Shannon entropy of an in-memory byte slice, reusing EntropyReader so
+// section entropy is computed identically to whole-file entropy.
+func entropyOfBytes(data []byte) float64 {
+	er := NewEntropyReader()
+	if _, err := er.Write(data); err != nil {
+		return 0
+	}
+	return er.Entropy()
+}
@@ -44,23 +44,32 @@ import (
 	"crypto/sha512"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
+	"hash"
 	"math"
 	"os"
 )
 
+// DefaultMaxFileSize is the maximum number of bytes NewEntropyReader will accept before Write
+// starts returning an error, unless overridden with EntropyReader.SetMaxSize. Callers that want a
+// configurable cap (e.g. a CLI flag) should use this as their flag's default.
+const DefaultMaxFileSize = 2147483648
+
 const (
-	// Max file size for entropy, etc. is 2GB
-	constMaxFileSize = 2147483648
-	// Chunk of data size to read in for entropy calc
-	constMaxEntropyChunk = 256000
+	// Max file size for entropy, etc. is 2GB by default. Use EntropyReader.SetMaxSize to override.
+	constMaxFileSize = DefaultMaxFileSize
 	// Need 4 bytes to determine basic ELF type
 	constMagicNumRead = 4
 	// Magic number for basic ELF type
 	constMagicNumElf = "7f454c46"
 )
 
+// ErrThresholdExceeded is returned by EntropyReader.Write once the running entropy has reached a
+// threshold set with SetThreshold. A caller driving the reader with io.Copy will see this as the
+// copy's error and can treat the entropy/hash values accumulated so far as final.
+var ErrThresholdExceeded = errors.New("fileutils: entropy threshold exceeded")
+
 // Pass in a path and we'll see if the magic number is Linux ELF type.
 func IsElfType(path string) (isElf bool, err error) {
 	var hexData [constMagicNumRead]byte
@@ -111,234 +120,335 @@ func IsElfType(path string) (isElf bool, err error) {
 	return false, nil
 }
 
-// Calculates entropy of a file.
-func Entropy(path string) (entropy float64, err error) {
-	var size int64
+// EntropyReader incrementally computes the Shannon entropy of a stream of bytes while also tee-ing
+// those bytes into MD5/SHA1/SHA256/SHA512 hashers, so a caller that needs entropy and hashes for
+// the same data (as checkFilePath does) can get both from a single read of a file. It satisfies
+// io.Writer and is meant to be driven with io.Copy/io.CopyBuffer from an os.File or any other
+// io.Reader, rather than requiring the whole file to be buffered in memory.
+//
+// Optionally, EntropyWindow arms sliding-window tracking that records the highest entropy seen
+// over any window-sized run of bytes, and SetThreshold arms an early-exit once the running
+// whole-file entropy reaches a given value.
+type EntropyReader struct {
+	size       int64
+	maxSize    int64
+	byteCounts [256]int64
+
+	windowSize       int
+	window           []byte
+	windowFilled     int
+	windowPos        int
+	windowCounts     [256]int64
+	maxWindowEntropy float64
+
+	threshold    float64
+	thresholdSet bool
+
+	// Accumulators for Indicators: the serial correlation coefficient and the Monte-Carlo pi
+	// estimate both depend on byte order, not just counts, so they have to be folded in as bytes
+	// arrive rather than derived from byteCounts afterwards.
+	haveFirstByte bool
+	firstByte     byte
+	lastByte      byte
+	sumBytes      int64
+	sumBytesSq    int64
+	sumByteProd   int64
+
+	mcPending     bool
+	mcPendingByte byte
+	mcInsideCount int64
+	mcPairCount   int64
+
+	md5    hash.Hash
+	sha1   hash.Hash
+	sha256 hash.Hash
+	sha512 hash.Hash
+}
 
-	if path == "" {
-		return entropy, fmt.Errorf("must provide a path to file to get entropy")
+// NewEntropyReader returns an EntropyReader ready to accept data via Write or io.Copy. It caps the
+// amount of data it will accept at constMaxFileSize; call SetMaxSize to raise, lower or remove
+// that cap before writing to it.
+func NewEntropyReader() *EntropyReader {
+	return &EntropyReader{
+		maxSize: constMaxFileSize,
+		md5:     md5.New(),
+		sha1:    sha1.New(),
+		sha256:  sha256.New(),
+		sha512:  sha512.New(),
 	}
+}
 
-	f, err := os.Open(path)
-	if err != nil {
-		return 0, fmt.Errorf("couldn't open path (%s) to get entropy: %v", path, err)
-	}
-	defer f.Close()
+// SetMaxSize overrides the maximum number of bytes the reader will accept before Write starts
+// returning an error. A value <= 0 disables the cap so arbitrarily large streams are allowed.
+func (r *EntropyReader) SetMaxSize(max int64) {
+	r.maxSize = max
+}
 
-	fStat, err := f.Stat()
-	if err != nil {
-		return 0, err
-	}
+// EntropyWindow arms sliding-window entropy tracking: as bytes are written, the reader keeps a
+// histogram of the most recent size bytes and records the highest entropy seen over any such
+// window via MaxWindowEntropy. This catches a packed or encrypted section embedded inside an
+// otherwise normal file that a single whole-file average would dilute. It must be called before
+// the first Write.
+func (r *EntropyReader) EntropyWindow(size int) {
+	r.windowSize = size
+	r.window = make([]byte, size)
+	r.windowFilled = 0
+	r.windowPos = 0
+}
 
-	if !fStat.Mode().IsRegular() {
-		return 0, fmt.Errorf("file (%s) is not a regular file to calculate entropy", path)
-	}
+// SetThreshold arms an early exit: once the running whole-file entropy reaches threshold, Write
+// returns ErrThresholdExceeded so an io.Copy driving the reader stops instead of reading the rest
+// of a possibly huge file. Entropy, MaxWindowEntropy and Hashes remain valid for the bytes seen so
+// far.
+func (r *EntropyReader) SetThreshold(threshold float64) {
+	r.threshold = threshold
+	r.thresholdSet = true
+}
 
-	size = fStat.Size()
-	// Zero sized file is zero entropy.
-	if size == 0 {
-		return 0, nil
+// Write implements io.Writer, folding p into the running entropy histogram, the sliding window (if
+// enabled) and the hashers. It never returns n < len(p) on success; on a size cap or threshold
+// being hit it still reports all of p as consumed since the accounting above has already happened,
+// matching the documented behavior of a Writer whose error ends the stream.
+func (r *EntropyReader) Write(p []byte) (int, error) {
+	n := len(p)
+	r.size += int64(n)
+
+	for _, b := range p {
+		r.byteCounts[b]++
+		r.observeIndicatorByte(b)
+	}
+	if r.windowSize > 0 {
+		for _, b := range p {
+			r.observeWindowByte(b)
+		}
 	}
 
-	if size > int64(constMaxFileSize) {
-		return 0, fmt.Errorf("file size (%d) is too large to calculate entropy (max allowed: %d)",
-			size, int64(constMaxFileSize))
+	if _, err := r.md5.Write(p); err != nil {
+		return n, err
 	}
-
-	dataBytes := make([]byte, constMaxEntropyChunk)
-	byteCounts := make([]int, 256)
-	for {
-		numBytesRead, err := f.Read(dataBytes)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return 0, err
-		}
-
-		// For each byte of the data that was read, increment the count
-		// of that number of bytes seen in the file in our byteCounts
-		// array
-		for i := 0; i < numBytesRead; i++ {
-			byteCounts[int(dataBytes[i])]++
-		}
+	if _, err := r.sha1.Write(p); err != nil {
+		return n, err
 	}
-
-	for i := 0; i < 256; i++ {
-		px := float64(byteCounts[i]) / float64(size)
-		if px > 0 {
-			entropy += -px * math.Log2(px)
-		}
+	if _, err := r.sha256.Write(p); err != nil {
+		return n, err
 	}
-
-	// Returns rounded to nearest two decimals.
-	return math.Round(entropy*100) / 100, nil
-}
-
-// Generates MD5 hash of a file
-func HashMD5(path string) (hash string, err error) {
-	if path == "" {
-		return hash, fmt.Errorf("must provide a path to file to hash")
+	if _, err := r.sha512.Write(p); err != nil {
+		return n, err
 	}
 
-	f, err := os.Open(path)
-	if err != nil {
-		return hash, fmt.Errorf("couldn't open path (%s): %v", path, err)
+	if r.maxSize > 0 && r.size > r.maxSize {
+		return n, fmt.Errorf("stream size (%d) exceeds max allowed (%d)", r.size, r.maxSize)
 	}
-	defer f.Close()
 
-	fStat, err := f.Stat()
-	if err != nil {
-		return hash, err
+	if r.thresholdSet && r.Entropy() >= r.threshold {
+		return n, ErrThresholdExceeded
 	}
 
-	if !fStat.Mode().IsRegular() {
-		return hash, fmt.Errorf("file (%s) is not a regular file to calculate hash", path)
-	}
+	return n, nil
+}
 
-	// Zero sized file is no hash.
-	if fStat.Size() == 0 {
-		return hash, nil
+// observeWindowByte folds a single byte into the sliding window histogram, evicting the oldest
+// byte once the window is full, and updates maxWindowEntropy whenever the window is full.
+func (r *EntropyReader) observeWindowByte(b byte) {
+	if r.windowFilled < r.windowSize {
+		r.window[r.windowFilled] = b
+		r.windowCounts[b]++
+		r.windowFilled++
+	} else {
+		old := r.window[r.windowPos]
+		r.windowCounts[old]--
+		r.window[r.windowPos] = b
+		r.windowCounts[b]++
+		r.windowPos = (r.windowPos + 1) % r.windowSize
+	}
+
+	if r.windowFilled == r.windowSize {
+		if e := shannonEntropy(r.windowCounts[:], int64(r.windowSize)); e > r.maxWindowEntropy {
+			r.maxWindowEntropy = e
+		}
 	}
+}
 
-	if fStat.Size() > int64(constMaxFileSize) {
-		return hash, fmt.Errorf("file size (%d) is too large to calculate hash (max allowed: %d)",
-			fStat.Size(), int64(constMaxFileSize))
+// observeIndicatorByte folds a single byte into the running accumulators Indicators needs beyond
+// the plain byte histogram: the Monte-Carlo pi estimate (non-overlapping byte pairs as 2D points)
+// and the serial correlation coefficient (sums of consecutive byte products).
+func (r *EntropyReader) observeIndicatorByte(b byte) {
+	if !r.haveFirstByte {
+		r.firstByte = b
+		r.haveFirstByte = true
+	} else {
+		r.sumByteProd += int64(r.lastByte) * int64(b)
+	}
+	r.lastByte = b
+	r.sumBytes += int64(b)
+	r.sumBytesSq += int64(b) * int64(b)
+
+	if r.mcPending {
+		x := scaleToUnitRange(r.mcPendingByte)
+		y := scaleToUnitRange(b)
+		if x*x+y*y <= 1.0 {
+			r.mcInsideCount++
+		}
+		r.mcPairCount++
+		r.mcPending = false
+	} else {
+		r.mcPendingByte = b
+		r.mcPending = true
 	}
+}
 
-	hashMD5 := md5.New()
-	_, err = io.Copy(hashMD5, f)
-	if err != nil {
-		return hash, fmt.Errorf("couldn't read path (%s) to get MD5 hash: %v", path, err)
-	}
+// scaleToUnitRange maps a byte (0-255) onto the [-1, 1] range used to plot it as one coordinate of
+// a Monte-Carlo sample point.
+func scaleToUnitRange(b byte) float64 {
+	return float64(b)/127.5 - 1.0
+}
 
-	hash = hex.EncodeToString(hashMD5.Sum(nil))
+// Entropy returns the Shannon entropy of all bytes written so far, rounded to two decimal places.
+func (r *EntropyReader) Entropy() float64 {
+	return shannonEntropy(r.byteCounts[:], r.size)
+}
 
-	return hash, nil
+// FileIndicators bundles several single-pass statistics that, combined with Shannon entropy, do a
+// better job than entropy alone at telling truly random/encrypted data apart from merely
+// compressed data: entropy alone produces false positives on compressed media (e.g. JPEG, video)
+// and false negatives on some encoders.
+type FileIndicators struct {
+	// Entropy is the Shannon entropy in bits (0-8).
+	Entropy float64
+	// ChiSquare is the chi-square statistic of the byte distribution against a uniform
+	// distribution over 256 buckets; truly random data centers close to 255.
+	ChiSquare float64
+	// MonteCarloPi estimates pi by treating consecutive byte pairs as 2D points and measuring the
+	// fraction that fall inside the unit circle; truly random data lands close to math.Pi.
+	MonteCarloPi float64
+	// SerialCorrelation measures how much each byte predicts the next; truly random data is close
+	// to zero, while structured or patterned data is not.
+	SerialCorrelation float64
+	// Mean is the arithmetic mean of all byte values; truly random data centers close to 127.5.
+	Mean float64
 }
 
-// Generates SHA1 hash of a file
-func HashSHA1(path string) (hash string, err error) {
-	if path == "" {
-		return hash, fmt.Errorf("must provide a path to file to hash")
-	}
+// RandomnessScore folds Entropy, ChiSquare, MonteCarloPi and SerialCorrelation into a single 0-100
+// composite score, where higher means more consistent with true randomness. It exists because any
+// one of those signals alone can be fooled: compressed media reads as high entropy while still
+// failing a chi-square or serial-correlation check that genuine ciphertext or a packed section
+// would pass.
+func (ind FileIndicators) RandomnessScore() float64 {
+	entropyScore := ind.Entropy / 8 * 100
+	chiScore := 100 - math.Min(100, math.Abs(ind.ChiSquare-255)/255*100)
+	piScore := 100 - math.Min(100, math.Abs(ind.MonteCarloPi-math.Pi)/math.Pi*100)
+	sccScore := 100 - math.Min(100, math.Abs(ind.SerialCorrelation)*100)
+
+	return math.Round((entropyScore+chiScore+piScore+sccScore)/4*100) / 100
+}
 
-	f, err := os.Open(path)
-	if err != nil {
-		return hash, fmt.Errorf("couldn't open path (%s): %v", path, err)
+// Indicators returns FileIndicators for all bytes written to the reader so far.
+func (r *EntropyReader) Indicators() FileIndicators {
+	return FileIndicators{
+		Entropy:           r.Entropy(),
+		ChiSquare:         r.chiSquare(),
+		MonteCarloPi:      r.monteCarloPi(),
+		SerialCorrelation: r.serialCorrelation(),
+		Mean:              r.mean(),
 	}
-	defer f.Close()
+}
 
-	fStat, err := f.Stat()
-	if err != nil {
-		return hash, err
+// chiSquare computes the chi-square statistic of the byte histogram against a uniform
+// distribution, rounded to two decimal places.
+func (r *EntropyReader) chiSquare() float64 {
+	if r.size == 0 {
+		return 0
 	}
 
-	if !fStat.Mode().IsRegular() {
-		return hash, fmt.Errorf("file (%s) is not a regular file to calculate hash", path)
+	expected := float64(r.size) / 256
+	var chi float64
+	for _, c := range r.byteCounts {
+		diff := float64(c) - expected
+		chi += diff * diff / expected
 	}
 
-	// Zero sized file is no hash.
-	if fStat.Size() == 0 {
-		return hash, nil
-	}
+	return math.Round(chi*100) / 100
+}
 
-	if fStat.Size() > int64(constMaxFileSize) {
-		return hash, fmt.Errorf("file size (%d) is too large to calculate hash (max allowed: %d)",
-			fStat.Size(), int64(constMaxFileSize))
+// mean computes the arithmetic mean of all bytes written, rounded to four decimal places.
+func (r *EntropyReader) mean() float64 {
+	if r.size == 0 {
+		return 0
 	}
 
-	hashSHA1 := sha1.New()
-	_, err = io.Copy(hashSHA1, f)
-	if err != nil {
-		return hash, fmt.Errorf("couldn't read path (%s) to get SHA1 hash: %v", path, err)
+	var sum int64
+	for b, c := range r.byteCounts {
+		sum += int64(b) * c
 	}
 
-	hash = hex.EncodeToString(hashSHA1.Sum(nil))
-
-	return hash, nil
+	return math.Round(float64(sum)/float64(r.size)*10000) / 10000
 }
 
-// Generates SHA256 hash of a file
-func HashSHA256(path string) (hash string, err error) {
-	if path == "" {
-		return hash, fmt.Errorf("must provide a path to file to hash")
-	}
-
-	f, err := os.Open(path)
-	if err != nil {
-		return hash, fmt.Errorf("couldn't open path (%s): %v", path, err)
+// monteCarloPi estimates pi from the fraction of non-overlapping byte-pair points that fell inside
+// the unit circle, rounded to four decimal places.
+func (r *EntropyReader) monteCarloPi() float64 {
+	if r.mcPairCount == 0 {
+		return 0
 	}
-	defer f.Close()
 
-	fStat, err := f.Stat()
-	if err != nil {
-		return hash, err
-	}
+	return math.Round(float64(r.mcInsideCount)/float64(r.mcPairCount)*4*10000) / 10000
+}
 
-	if !fStat.Mode().IsRegular() {
-		return hash, fmt.Errorf("file (%s) is not a regular file to calculate hash", path)
+// serialCorrelation computes the serial correlation coefficient between consecutive bytes, closing
+// the sequence into a loop (pairing the last byte with the first) the same way the classic "ent"
+// tool does, rounded to four decimal places.
+func (r *EntropyReader) serialCorrelation() float64 {
+	n := r.size
+	if n < 2 {
+		return 0
 	}
 
-	// Zero sized file is no hash.
-	if fStat.Size() == 0 {
-		return hash, nil
-	}
+	sumProd := float64(r.sumByteProd+int64(r.lastByte)*int64(r.firstByte)) / float64(n)
+	mean := float64(r.sumBytes) / float64(n)
+	meanSq := mean * mean
+	variance := float64(r.sumBytesSq)/float64(n) - meanSq
 
-	if fStat.Size() > int64(constMaxFileSize) {
-		return hash, fmt.Errorf("file size (%d) is too large to calculate hash (max allowed: %d)",
-			fStat.Size(), int64(constMaxFileSize))
+	if variance == 0 {
+		return 0
 	}
 
-	hashSHA256 := sha256.New()
-	_, err = io.Copy(hashSHA256, f)
-	if err != nil {
-		return hash, fmt.Errorf("couldn't read path (%s) to get SHA256 hash: %v", path, err)
-	}
-
-	hash = hex.EncodeToString(hashSHA256.Sum(nil))
-
-	return hash, nil
+	return math.Round((sumProd-meanSq)/variance*10000) / 10000
 }
 
-// Generates SHA512 hash of a file
-func HashSHA512(path string) (hash string, err error) {
-	if path == "" {
-		return hash, fmt.Errorf("must provide a path to file to hash")
-	}
-
-	f, err := os.Open(path)
-	if err != nil {
-		return hash, fmt.Errorf("couldn't open path (%s): %v", path, err)
-	}
-	defer f.Close()
-
-	fStat, err := f.Stat()
-	if err != nil {
-		return hash, err
-	}
+// MaxWindowEntropy returns the highest entropy observed over any EntropyWindow-sized run of bytes.
+// It is zero if EntropyWindow was never called, or fewer than that many bytes have been written.
+func (r *EntropyReader) MaxWindowEntropy() float64 {
+	return r.maxWindowEntropy
+}
 
-	if !fStat.Mode().IsRegular() {
-		return hash, fmt.Errorf("file (%s) is not a regular file to calculate hash", path)
-	}
+// Size returns the number of bytes written to the reader so far.
+func (r *EntropyReader) Size() int64 {
+	return r.size
+}
 
-	// Zero sized file is no hash.
-	if fStat.Size() == 0 {
-		return hash, nil
-	}
+// Hashes returns hex-encoded MD5, SHA1, SHA256 and SHA512 digests of all bytes written so far.
+func (r *EntropyReader) Hashes() (md5hash, sha1hash, sha256hash, sha512hash string) {
+	return hex.EncodeToString(r.md5.Sum(nil)),
+		hex.EncodeToString(r.sha1.Sum(nil)),
+		hex.EncodeToString(r.sha256.Sum(nil)),
+		hex.EncodeToString(r.sha512.Sum(nil))
+}
 
-	if fStat.Size() > int64(constMaxFileSize) {
-		return hash, fmt.Errorf("file size (%d) is too large to calculate hash (max allowed: %d)",
-			fStat.Size(), int64(constMaxFileSize))
+// shannonEntropy computes the Shannon entropy, in bits, of a 256-bucket byte histogram covering
+// total bytes, rounded to two decimal places.
+func shannonEntropy(counts []int64, total int64) float64 {
+	if total == 0 {
+		return 0
 	}
 
-	hashSHA512 := sha512.New()
-	_, err = io.Copy(hashSHA512, f)
-	if err != nil {
-		return hash, fmt.Errorf("couldn't read path (%s) to get SHA512 hash: %v", path, err)
+	var entropy float64
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		px := float64(c) / float64(total)
+		entropy += -px * math.Log2(px)
 	}
 
-	hash = hex.EncodeToString(hashSHA512.Sum(nil))
-
-	return hash, nil
+	return math.Round(entropy*100) / 100
 }
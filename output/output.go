@@ -0,0 +1,350 @@
+// Sandfly filescan structured output writers
+package output
+
+/*
+This package gives scan results a stable, documented schema independent of how they're rendered,
+so the same Record can be printed as the original human-readable text, as proper RFC 4180 CSV (the
+hand-rolled delimiter-joined CSV elsewhere in this tool breaks on a path containing the delimiter),
+or as JSON/NDJSON for piping into jq, a SIEM, or a log collector. Record intentionally carries
+everything a caller might want (hashes, indicators, ELF sections, rule hits) with omitempty tags, so
+JSON/NDJSON consumers get the full picture while CSV/text only print what was actually gathered.
+*/
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// RuleHit is one rule that fired for a file, as reported by the rules package.
+type RuleHit struct {
+	Name     string `json:"name"`
+	Severity string `json:"severity"`
+}
+
+// Indicators mirrors fileutils.FileIndicators plus its derived randomness score, carried here so
+// the output package doesn't need to depend on fileutils.
+type Indicators struct {
+	ChiSquare         float64 `json:"chi_square"`
+	MonteCarloPi      float64 `json:"monte_carlo_pi"`
+	SerialCorrelation float64 `json:"serial_correlation"`
+	Mean              float64 `json:"mean"`
+	RandomnessScore   float64 `json:"randomness_score"`
+}
+
+// SectionReport is one ELF section's entropy, as reported by fileutils.AnalyzeELFSections.
+type SectionReport struct {
+	Name    string  `json:"name"`
+	Size    uint64  `json:"size"`
+	Entropy float64 `json:"entropy"`
+}
+
+// Region carries the process-memory-specific fields of a -procmem hit, as reported by
+// procscan.ScanPID. Its Name/Path/Size/Entropy/Hashes still travel on the surrounding Record like
+// any other result, so a JSON/CSV/text consumer handles a region the same way it handles a file.
+type Region struct {
+	PID         int    `json:"pid"`
+	Start       uint64 `json:"start"`
+	End         uint64 `json:"end"`
+	Permissions string `json:"permissions"`
+}
+
+// Record is the stable schema for one scanned file or -procmem memory region. Fields beyond the
+// always-present identity and entropy data are only populated when the corresponding flag
+// (-indicators, -elf-sections, -rules, -procmem) was used, and are omitted from JSON/NDJSON rather
+// than emitted as zero values.
+type Record struct {
+	Timestamp string `json:"timestamp"`
+	Hostname  string `json:"hostname"`
+
+	Path    string  `json:"path"`
+	Name    string  `json:"name"`
+	Size    int64   `json:"size"`
+	Entropy float64 `json:"entropy"`
+	ELF     bool    `json:"elf"`
+
+	// MaxWindowEntropy is the highest entropy seen over any -window-size sliding window. It's a
+	// pointer so a scan that never enabled window tracking omits the field instead of printing a
+	// misleading 0.
+	MaxWindowEntropy *float64 `json:"max_window_entropy,omitempty"`
+
+	Hashes map[string]string `json:"hashes,omitempty"`
+
+	Indicators *Indicators `json:"indicators,omitempty"`
+
+	ELFSections      []SectionReport `json:"elf_sections,omitempty"`
+	PackerIndicators []string        `json:"packer_indicators,omitempty"`
+
+	RuleHits []RuleHit `json:"rule_hits,omitempty"`
+
+	// Truncated and BytesRead record that -early-exit-threshold stopped reading before EOF: Entropy,
+	// MaxWindowEntropy and Hashes reflect only the first BytesRead bytes of a Size-byte file, not the
+	// whole thing. Both are left zero for a file that was read in full.
+	Truncated bool  `json:"truncated,omitempty"`
+	BytesRead int64 `json:"bytes_read,omitempty"`
+
+	// Region is set instead of the file fields above being meaningful beyond Path/Name/Size/Entropy
+	// when this Record describes a -procmem memory region rather than a file.
+	Region *Region `json:"region,omitempty"`
+}
+
+// OutputWriter renders Records in some format. WriteRecord is called once per matching file;
+// Close finalizes the output (closing a JSON array, flushing a CSV writer) and must be called
+// after the last WriteRecord.
+type OutputWriter interface {
+	WriteRecord(rec Record) error
+	Close() error
+}
+
+// TextWriter renders each Record as the original multi-line, human-readable block.
+type TextWriter struct {
+	w io.Writer
+}
+
+// NewTextWriter returns a TextWriter that writes to w.
+func NewTextWriter(w io.Writer) *TextWriter {
+	return &TextWriter{w: w}
+}
+
+func (t *TextWriter) WriteRecord(rec Record) error {
+	if rec.Region != nil {
+		_, err := fmt.Fprintf(t.w, "pid: %d\nstart: %#x\nend: %#x\npermissions: %s\nbacking: %s\nsize: %d\nentropy: %.2f\nsha256: %s\n\n",
+			rec.Region.PID, rec.Region.Start, rec.Region.End, rec.Region.Permissions, rec.Path, rec.Size, rec.Entropy, rec.Hashes["sha256"])
+		return err
+	}
+
+	if _, err := fmt.Fprintf(t.w, "filename: %s\npath: %s\nentropy: %.2f\nelf: %v\n",
+		rec.Name, rec.Path, rec.Entropy, rec.ELF); err != nil {
+		return err
+	}
+
+	if rec.MaxWindowEntropy != nil {
+		if _, err := fmt.Fprintf(t.w, "max_window_entropy: %.2f\n", *rec.MaxWindowEntropy); err != nil {
+			return err
+		}
+	}
+
+	if rec.Truncated {
+		if _, err := fmt.Fprintf(t.w, "truncated: true\nbytes_read: %d\n", rec.BytesRead); err != nil {
+			return err
+		}
+	}
+
+	for _, hit := range rec.RuleHits {
+		if _, err := fmt.Fprintf(t.w, "rule: %s\nseverity: %s\n", hit.Name, hit.Severity); err != nil {
+			return err
+		}
+	}
+
+	if md5, ok := rec.Hashes["md5"]; ok {
+		if _, err := fmt.Fprintf(t.w, "md5: %s\nsha1: %s\nsha256: %s\nsha512: %s\n",
+			md5, rec.Hashes["sha1"], rec.Hashes["sha256"], rec.Hashes["sha512"]); err != nil {
+			return err
+		}
+	}
+
+	if rec.Indicators != nil {
+		ind := rec.Indicators
+		if _, err := fmt.Fprintf(t.w, "chi_square: %.2f\nmonte_carlo_pi: %.4f\nserial_correlation: %.4f\nmean: %.4f\nrandomness_score: %.2f\n",
+			ind.ChiSquare, ind.MonteCarloPi, ind.SerialCorrelation, ind.Mean, ind.RandomnessScore); err != nil {
+			return err
+		}
+	}
+
+	for _, sec := range rec.ELFSections {
+		if _, err := fmt.Fprintf(t.w, "section: %s\nsection_size: %d\nsection_entropy: %.2f\n",
+			sec.Name, sec.Size, sec.Entropy); err != nil {
+			return err
+		}
+	}
+	if len(rec.PackerIndicators) > 0 {
+		if _, err := fmt.Fprintf(t.w, "packer_indicators: %s\n", strings.Join(rec.PackerIndicators, ",")); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintln(t.w)
+	return err
+}
+
+func (t *TextWriter) Close() error {
+	return nil
+}
+
+// csvHeader is the fixed column set every CSVWriter row has, file or -procmem region alike: a
+// region's Name/Path/Size/Entropy/Hashes["sha256"] travel in the same columns a file's do, with the
+// columns that don't apply to it (rules, severities, the non-SHA256 hashes) left blank and elf
+// reading false.
+var csvHeader = []string{"name", "path", "size", "entropy", "elf", "rules", "severities", "md5", "sha1", "sha256", "sha512"}
+
+// csvWindowEntropyColumn is the extra column CSVWriter appends when constructed with
+// CSVColumns.MaxWindowEntropy=true.
+var csvWindowEntropyColumn = []string{"max_window_entropy"}
+
+// csvELFSectionColumns are the extra columns CSVWriter appends when constructed with
+// CSVColumns.ELFSections=true.
+var csvELFSectionColumns = []string{"elf_sections", "packer_indicators"}
+
+// csvTruncatedColumns are the extra columns CSVWriter appends when constructed with
+// CSVColumns.Truncated=true.
+var csvTruncatedColumns = []string{"truncated", "bytes_read"}
+
+// csvRegionColumns are the extra columns CSVWriter appends when constructed with
+// CSVColumns.Regions=true, left blank for a row whose Record isn't a -procmem region.
+var csvRegionColumns = []string{"pid", "start", "end", "permissions"}
+
+// CSVColumns selects which optional column groups NewCSVWriter appends to the fixed csvHeader
+// column set, the same "bundle the growing flag list into one value" approach scanOptions uses for
+// checkFilePath/reportFile.
+type CSVColumns struct {
+	MaxWindowEntropy bool
+	ELFSections      bool
+	Truncated        bool
+	Regions          bool
+}
+
+// CSVWriter renders each Record as one properly quoted/escaped CSV row via encoding/csv, so a path
+// or rule name containing the delimiter or a quote doesn't corrupt the output the way the tool's
+// original hand-joined CSV did. Every row, file or region, is written against the same header so
+// mixing -proc -procmem into one -csv stream still produces rectangular, parseable CSV.
+type CSVWriter struct {
+	w           *csv.Writer
+	cols        CSVColumns
+	wroteHeader bool
+}
+
+// NewCSVWriter returns a CSVWriter that writes to w using delim as the field separator, appending
+// the optional column groups selected by cols after the fixed csvHeader columns.
+func NewCSVWriter(w io.Writer, delim rune, cols CSVColumns) *CSVWriter {
+	cw := csv.NewWriter(w)
+	cw.Comma = delim
+	return &CSVWriter{w: cw, cols: cols}
+}
+
+func (c *CSVWriter) WriteRecord(rec Record) error {
+	if !c.wroteHeader {
+		header := append([]string{}, csvHeader...)
+		if c.cols.MaxWindowEntropy {
+			header = append(header, csvWindowEntropyColumn...)
+		}
+		if c.cols.ELFSections {
+			header = append(header, csvELFSectionColumns...)
+		}
+		if c.cols.Truncated {
+			header = append(header, csvTruncatedColumns...)
+		}
+		if c.cols.Regions {
+			header = append(header, csvRegionColumns...)
+		}
+		if err := c.w.Write(header); err != nil {
+			return err
+		}
+		c.wroteHeader = true
+	}
+
+	var names, severities []string
+	for _, hit := range rec.RuleHits {
+		names = append(names, hit.Name)
+		severities = append(severities, hit.Severity)
+	}
+
+	row := []string{
+		rec.Name,
+		rec.Path,
+		fmt.Sprintf("%d", rec.Size),
+		fmt.Sprintf("%.2f", rec.Entropy),
+		fmt.Sprintf("%v", rec.ELF),
+		strings.Join(names, "|"),
+		strings.Join(severities, "|"),
+		rec.Hashes["md5"],
+		rec.Hashes["sha1"],
+		rec.Hashes["sha256"],
+		rec.Hashes["sha512"],
+	}
+
+	if c.cols.MaxWindowEntropy {
+		var windowEntropy string
+		if rec.MaxWindowEntropy != nil {
+			windowEntropy = fmt.Sprintf("%.2f", *rec.MaxWindowEntropy)
+		}
+		row = append(row, windowEntropy)
+	}
+
+	if c.cols.ELFSections {
+		var sections []string
+		for _, sec := range rec.ELFSections {
+			sections = append(sections, fmt.Sprintf("%s:%d:%.2f", sec.Name, sec.Size, sec.Entropy))
+		}
+		row = append(row, strings.Join(sections, "|"), strings.Join(rec.PackerIndicators, "|"))
+	}
+
+	if c.cols.Truncated {
+		row = append(row, fmt.Sprintf("%v", rec.Truncated), fmt.Sprintf("%d", rec.BytesRead))
+	}
+
+	if c.cols.Regions {
+		var pid, start, end, permissions string
+		if rec.Region != nil {
+			pid = strconv.Itoa(rec.Region.PID)
+			start = fmt.Sprintf("%#x", rec.Region.Start)
+			end = fmt.Sprintf("%#x", rec.Region.End)
+			permissions = rec.Region.Permissions
+		}
+		row = append(row, pid, start, end, permissions)
+	}
+
+	return c.w.Write(row)
+}
+
+func (c *CSVWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// JSONWriter collects every Record written and emits them as a single JSON array on Close. Use
+// NDJSONWriter instead when downstream consumers need to start processing before the scan finishes.
+type JSONWriter struct {
+	w       io.Writer
+	records []Record
+}
+
+// NewJSONWriter returns a JSONWriter that writes to w.
+func NewJSONWriter(w io.Writer) *JSONWriter {
+	return &JSONWriter{w: w}
+}
+
+func (j *JSONWriter) WriteRecord(rec Record) error {
+	j.records = append(j.records, rec)
+	return nil
+}
+
+func (j *JSONWriter) Close() error {
+	enc := json.NewEncoder(j.w)
+	enc.SetIndent("", "  ")
+	if j.records == nil {
+		j.records = []Record{}
+	}
+	return enc.Encode(j.records)
+}
+
+// NDJSONWriter renders each Record as its own JSON object on its own line as soon as it's written,
+// the newline-delimited JSON format most log collectors and SIEMs expect.
+type NDJSONWriter struct {
+	enc *json.Encoder
+}
+
+// NewNDJSONWriter returns an NDJSONWriter that writes to w.
+func NewNDJSONWriter(w io.Writer) *NDJSONWriter {
+	return &NDJSONWriter{enc: json.NewEncoder(w)}
+}
+
+func (n *NDJSONWriter) WriteRecord(rec Record) error {
+	return n.enc.Encode(rec)
+}
+
+func (n *NDJSONWriter) Close() error {
+	return nil
+}
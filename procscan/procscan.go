@@ -0,0 +1,168 @@
+// Sandfly filescan process memory entropy scanning utilities
+package procscan
+
+/*
+This package extends entropy scanning beyond a process's on-disk executable to its live memory.
+For a given PID it parses /proc/<pid>/maps and computes Shannon entropy plus a SHA256 digest over
+each mapped region that is executable and either anonymous or writable, reading the bytes straight
+out of /proc/<pid>/mem. A high-entropy anonymous+executable region is the classic signature of
+injected or packed code that never touches disk, which scanning /proc/<pid>/exe alone can't catch.
+
+Sandfly Security produces an agentless intrusion detection and incident response platform for Linux.
+You can find out more about how it works at: https://www.sandflysecurity.com
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gihub.com/sandflysecurity/sandfly-entropyscan/fileutils"
+)
+
+const (
+	// constAnonBacking is reported as the Backing field for mappings with no backing file.
+	constAnonBacking = "[anon]"
+	// constMaxRegionSize is the largest mapped region we'll read into memory; larger regions are
+	// skipped, not read in part.
+	constMaxRegionSize = 268435456 // 256MB
+)
+
+// RegionReport describes one mapped memory region that was read and analyzed.
+type RegionReport struct {
+	Start       uint64
+	End         uint64
+	Permissions string
+	// Backing is the mapped file's path, or constAnonBacking for anonymous memory.
+	Backing string
+	Size    int64
+	Entropy float64
+	SHA256  string
+}
+
+// mapsRegion is one parsed line of /proc/<pid>/maps.
+type mapsRegion struct {
+	start, end uint64
+	perms      string
+	backing    string
+}
+
+// ScanPID parses /proc/<pid>/maps and returns a RegionReport for each executable region that is
+// either anonymous or writable (the shapes injected/packed in-memory code takes). Regions that
+// can't be read, e.g. guard pages or ones too large to scan, are skipped rather than failing the
+// whole PID; ScanPID only returns an error if the process's maps or mem file couldn't be opened, or
+// its maps file couldn't be read.
+func ScanPID(pid int) ([]RegionReport, error) {
+	mapsPath := filepath.Join("/proc", strconv.Itoa(pid), "maps")
+	memPath := filepath.Join("/proc", strconv.Itoa(pid), "mem")
+
+	mapsFile, err := os.Open(mapsPath)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open %s: %v", mapsPath, err)
+	}
+	defer mapsFile.Close()
+
+	memFile, err := os.Open(memPath)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open %s: %v", memPath, err)
+	}
+	defer memFile.Close()
+
+	var reports []RegionReport
+
+	scanner := bufio.NewScanner(mapsFile)
+	for scanner.Scan() {
+		region, ok, err := parseMapsLine(scanner.Text())
+		if err != nil || !ok || !isInterestingRegion(region) {
+			continue
+		}
+
+		size := int64(region.end - region.start)
+		if size <= 0 || size > constMaxRegionSize {
+			continue
+		}
+
+		data, err := readRegion(memFile, region.start, size)
+		if err != nil || len(data) == 0 {
+			continue
+		}
+
+		er := fileutils.NewEntropyReader()
+		if _, err := er.Write(data); err != nil {
+			continue
+		}
+		_, _, sha256hash, _ := er.Hashes()
+
+		reports = append(reports, RegionReport{
+			Start:       region.start,
+			End:         region.end,
+			Permissions: region.perms,
+			Backing:     region.backing,
+			Size:        int64(len(data)),
+			Entropy:     er.Entropy(),
+			SHA256:      sha256hash,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return reports, fmt.Errorf("error reading %s: %v", mapsPath, err)
+	}
+
+	return reports, nil
+}
+
+// parseMapsLine parses one /proc/<pid>/maps line, e.g.:
+//
+//	7f1234560000-7f1234580000 r-xp 00000000 08:01 123456  /usr/lib/libc.so.6
+func parseMapsLine(line string) (region mapsRegion, ok bool, err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 5 {
+		return region, false, fmt.Errorf("malformed maps line: %q", line)
+	}
+
+	start, end, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return region, false, fmt.Errorf("malformed address range: %q", fields[0])
+	}
+	startAddr, err := strconv.ParseUint(start, 16, 64)
+	if err != nil {
+		return region, false, err
+	}
+	endAddr, err := strconv.ParseUint(end, 16, 64)
+	if err != nil {
+		return region, false, err
+	}
+
+	region.start = startAddr
+	region.end = endAddr
+	region.perms = fields[1]
+	region.backing = constAnonBacking
+	if len(fields) >= 6 {
+		region.backing = fields[5]
+	}
+
+	return region, true, nil
+}
+
+// isInterestingRegion reports whether a region is worth reading: it must be executable, and
+// either anonymous (no backing file) or writable, since a legitimately read-only file-backed
+// executable mapping is just normal code.
+func isInterestingRegion(region mapsRegion) bool {
+	if !strings.Contains(region.perms, "x") {
+		return false
+	}
+	return region.backing == constAnonBacking || strings.Contains(region.perms, "w")
+}
+
+// readRegion reads size bytes starting at start out of mem.
+func readRegion(mem *os.File, start uint64, size int64) ([]byte, error) {
+	data := make([]byte, size)
+	n, err := mem.ReadAt(data, int64(start))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data[:n], nil
+}
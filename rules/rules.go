@@ -0,0 +1,277 @@
+// Sandfly filescan rule engine combining entropy, ELF, size and hash signals
+package rules
+
+/*
+This package lets a rules file describe detections in terms of the signals sandfly-entropyscan
+already gathers while scanning a file: whether it's an ELF, its size, its whole-file entropy, its
+peak sliding-window entropy, and its MD5/SHA1/SHA256/SHA512 hashes. A rule fires when every
+condition it sets is satisfied, turning the tool from "print anything above X entropy" into a
+rule-driven scanner whose hits are labelled with a name and severity for an IR team to act on.
+
+Rules are written as a small line-oriented DSL rather than full YAML, to avoid pulling in a parser
+dependency for what is, in practice, a flat list of scalar fields:
+
+	- name: packed-elf
+	  severity: high
+	  elf: true
+	  min_entropy: 7.2
+
+	- name: known-bad-hash
+	  severity: critical
+	  sha256: [deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef]
+
+	- name: known-good
+	  allow: true
+	  sha256: [0000000000000000000000000000000000000000000000000000000000000000]
+
+Each "- " starts a new rule; subsequent "key: value" lines until the next "- " set that rule's
+fields. Blank lines and lines starting with # are ignored. An "allow" rule is an allowlist: if it
+matches a file, Evaluate reports no hits at all for that file regardless of what else matches.
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Rule describes one named detection: a set of conditions that, taken together, must all be
+// satisfied for the rule to match a file. A zero-value condition (MinEntropy 0, MaxSize 0, an
+// empty hash list, ...) is treated as "not set" and never constrains the match.
+type Rule struct {
+	Name     string
+	Severity string
+
+	// Allow marks this rule as a known-good allowlist entry: a match suppresses every other hit
+	// for the file instead of being reported itself.
+	Allow bool
+
+	ELFOnly            bool
+	MinEntropy         float64
+	MaxEntropy         float64
+	MinWindowEntropy   float64
+	MinRandomnessScore float64
+	MinSize            int64
+	MaxSize            int64
+
+	MD5    []string
+	SHA1   []string
+	SHA256 []string
+	SHA512 []string
+}
+
+// Subject carries the signals gathered for a single file that rules are matched against.
+type Subject struct {
+	ELF              bool
+	Size             int64
+	Entropy          float64
+	MaxWindowEntropy float64
+	RandomnessScore  float64
+	MD5              string
+	SHA1             string
+	SHA256           string
+	SHA512           string
+}
+
+// Match reports whether every condition set on the rule is satisfied by subject.
+func (r Rule) Match(s Subject) bool {
+	if r.ELFOnly && !s.ELF {
+		return false
+	}
+	if r.MinEntropy > 0 && s.Entropy < r.MinEntropy {
+		return false
+	}
+	if r.MaxEntropy > 0 && s.Entropy > r.MaxEntropy {
+		return false
+	}
+	if r.MinWindowEntropy > 0 && s.MaxWindowEntropy < r.MinWindowEntropy {
+		return false
+	}
+	if r.MinRandomnessScore > 0 && s.RandomnessScore < r.MinRandomnessScore {
+		return false
+	}
+	if r.MinSize > 0 && s.Size < r.MinSize {
+		return false
+	}
+	if r.MaxSize > 0 && s.Size > r.MaxSize {
+		return false
+	}
+	if len(r.MD5) > 0 && !containsFold(r.MD5, s.MD5) {
+		return false
+	}
+	if len(r.SHA1) > 0 && !containsFold(r.SHA1, s.SHA1) {
+		return false
+	}
+	if len(r.SHA256) > 0 && !containsFold(r.SHA256, s.SHA256) {
+		return false
+	}
+	if len(r.SHA512) > 0 && !containsFold(r.SHA512, s.SHA512) {
+		return false
+	}
+	return true
+}
+
+// Evaluate runs subject against every rule in order and returns the rules that fired. If an allow
+// rule matches, Evaluate stops and returns no hits at all: allowlisting a known-good hash
+// suppresses every other rule for that file.
+func Evaluate(rules []Rule, s Subject) []Rule {
+	var hits []Rule
+	for _, r := range rules {
+		if r.Match(s) {
+			if r.Allow {
+				return nil
+			}
+			hits = append(hits, r)
+		}
+	}
+	return hits
+}
+
+// LoadRules reads rule definitions from path. See the package doc comment for the file format.
+func LoadRules(path string) (rules []Rule, err error) {
+	if path == "" {
+		return nil, fmt.Errorf("must provide a path to a rules file")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open rules file (%s): %v", path, err)
+	}
+	defer f.Close()
+
+	var cur *Rule
+
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "- ") {
+			if cur != nil {
+				rules = append(rules, *cur)
+			}
+			cur = &Rule{}
+			line = strings.TrimPrefix(line, "- ")
+		}
+
+		if cur == nil {
+			return nil, fmt.Errorf("rules file (%s) line %d: field outside of a rule (missing leading \"- \")", path, lineNum)
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("rules file (%s) line %d: expected \"key: value\", got %q", path, lineNum, line)
+		}
+
+		if err := setField(cur, strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+			return nil, fmt.Errorf("rules file (%s) line %d: %v", path, lineNum, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rules file (%s): %v", path, err)
+	}
+	if cur != nil {
+		rules = append(rules, *cur)
+	}
+
+	return rules, nil
+}
+
+// setField assigns a single "key: value" pair parsed out of a rule block onto r.
+func setField(r *Rule, key, value string) error {
+	switch key {
+	case "name":
+		r.Name = value
+	case "severity":
+		r.Severity = value
+	case "allow":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("allow: %v", err)
+		}
+		r.Allow = b
+	case "elf":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("elf: %v", err)
+		}
+		r.ELFOnly = b
+	case "min_entropy":
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("min_entropy: %v", err)
+		}
+		r.MinEntropy = v
+	case "max_entropy":
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("max_entropy: %v", err)
+		}
+		r.MaxEntropy = v
+	case "min_window_entropy":
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("min_window_entropy: %v", err)
+		}
+		r.MinWindowEntropy = v
+	case "min_randomness_score":
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("min_randomness_score: %v", err)
+		}
+		r.MinRandomnessScore = v
+	case "min_size":
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("min_size: %v", err)
+		}
+		r.MinSize = v
+	case "max_size":
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("max_size: %v", err)
+		}
+		r.MaxSize = v
+	case "md5":
+		r.MD5 = parseHashList(value)
+	case "sha1":
+		r.SHA1 = parseHashList(value)
+	case "sha256":
+		r.SHA256 = parseHashList(value)
+	case "sha512":
+		r.SHA512 = parseHashList(value)
+	default:
+		return fmt.Errorf("unknown rule field %q", key)
+	}
+	return nil
+}
+
+// parseHashList splits a "[hash, hash, ...]" value into lowercased, trimmed hash strings.
+func parseHashList(value string) []string {
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	parts := strings.Split(value, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// containsFold reports whether value (case-insensitively) appears in list.
+func containsFold(list []string, value string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
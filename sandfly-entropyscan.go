@@ -36,15 +36,23 @@ Author: @SandflySecurity
 */
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"strconv"
+	"sync"
+	"time"
 
 	"gihub.com/sandflysecurity/sandfly-entropyscan/fileutils"
+	"gihub.com/sandflysecurity/sandfly-entropyscan/output"
+	"gihub.com/sandflysecurity/sandfly-entropyscan/procscan"
+	"gihub.com/sandflysecurity/sandfly-entropyscan/rules"
 )
 
 const (
@@ -58,14 +66,25 @@ const (
 	constMinPID = 1
 	// constMaxPID maximum PID value allowed for process checks. 64bit linux is 2^22. This value is a limiter.
 	constMaxPID = 4194304
+	// constDefaultWindowSize is the sliding-window size used for a rule's min_window_entropy when
+	// -window-size wasn't given explicitly.
+	constDefaultWindowSize = 4096
 )
 
 type fileData struct {
-	path    string
-	name    string
-	entropy float64
-	elf     bool
-	hash    hashes
+	path             string
+	name             string
+	size             int64
+	entropy          float64
+	maxWindowEntropy float64
+	elf              bool
+	hash             hashes
+	indicators       fileutils.FileIndicators
+	elfSections      fileutils.ELFAnalysis
+	// truncated and bytesRead record that -early-exit-threshold stopped reading before EOF: entropy,
+	// maxWindowEntropy and hash reflect only the first bytesRead bytes of a size-byte file.
+	truncated bool
+	bytesRead int64
 }
 
 type hashes struct {
@@ -75,6 +94,24 @@ type hashes struct {
 	sha512 string
 }
 
+// scanOptions bundles the CLI flags that shape how a single file is checked and reported, so they
+// can be threaded through checkFilePath/reportFile/scanDirectory as one value instead of a long,
+// growing list of positional parameters.
+type scanOptions struct {
+	elfOnly            bool
+	entropyMaxVal      float64
+	ruleSet            []rules.Rule
+	csvOutput          bool
+	delimChar          string
+	showIndicators     bool
+	elfSections        bool
+	writer             output.OutputWriter
+	hostname           string
+	windowSize         int
+	maxFileSize        int64
+	earlyExitThreshold float64
+}
+
 func main() {
 	var filePath string
 	var dirPath string
@@ -84,6 +121,16 @@ func main() {
 	var procOnly bool
 	var csvOutput bool
 	var version bool
+	var rulesPath string
+	var workers int
+	var procMem bool
+	var showIndicators bool
+	var elfSections bool
+	var jsonOutput bool
+	var ndjsonOutput bool
+	var windowSize int
+	var maxFileSize int64
+	var earlyExitThreshold float64
 
 	flag.StringVar(&filePath, "file", "", "full path to a single file to analyze")
 	flag.StringVar(&dirPath, "dir", "", "directory name to analyze")
@@ -91,8 +138,18 @@ func main() {
 	flag.Float64Var(&entropyMaxVal, "entropy", 0, "show any file with entropy greater than or equal to this value (0.0 - 8.0 max 8.0, default is 0)")
 	flag.BoolVar(&elfOnly, "elf", false, "only check ELF executables")
 	flag.BoolVar(&procOnly, "proc", false, "check running processes")
-	flag.BoolVar(&csvOutput, "csv", false, "output results in CSV format (filename, path, entropy, elf_file [true|false], MD5, SHA1, SHA256, SHA512)")
+	flag.BoolVar(&csvOutput, "csv", false, "output results in CSV format (name, path, size, entropy, elf, rules, severities, md5, sha1, sha256, sha512; columns are appended for max_window_entropy when window tracking is enabled, elf_sections/packer_indicators with -elf-sections, truncated/bytes_read with -early-exit-threshold, and pid/start/end/permissions with -procmem)")
 	flag.BoolVar(&version, "version", false, "show version and exit")
+	flag.StringVar(&rulesPath, "rules", "", "path to a rules file; reports which rule fired per file instead of a bare entropy threshold")
+	flag.IntVar(&workers, "workers", runtime.NumCPU(), "number of concurrent workers for directory scans (-dir)")
+	flag.BoolVar(&procMem, "procmem", false, "with -proc, also scan each process's executable/writable memory regions for entropy")
+	flag.BoolVar(&showIndicators, "indicators", false, "also print chi-square, Monte-Carlo pi, serial correlation, mean and a composite randomness score")
+	flag.BoolVar(&elfSections, "elf-sections", false, "for ELF files, also print per-section entropy and packer signature indicators")
+	flag.BoolVar(&jsonOutput, "json", false, "output results as a single JSON array")
+	flag.BoolVar(&ndjsonOutput, "ndjson", false, "output results as newline-delimited JSON, one object per file")
+	flag.IntVar(&windowSize, "window-size", 0, "bytes; report the maximum entropy seen over any sliding window of this size, catching a packed section inside an otherwise normal file (0 disables; a rules file using min_window_entropy enables it automatically with a default window size if this isn't set)")
+	flag.Int64Var(&maxFileSize, "max-size", fileutils.DefaultMaxFileSize, "maximum file size in bytes to read for entropy/hashing; files larger than this are skipped with an error (0 disables the cap)")
+	flag.Float64Var(&earlyExitThreshold, "early-exit-threshold", 0, "entropy (0.0-8.0); with no rules file loaded, stop reading a file early once its running entropy reaches this value, trading an exact whole-file entropy/hash for faster scans of huge files (0 disables)")
 	flag.Parse()
 
 	if version {
@@ -108,104 +165,353 @@ func main() {
 		log.Fatal("min entropy value is 0.0")
 	}
 
+	var ruleSet []rules.Rule
+	if rulesPath != "" {
+		var err error
+		ruleSet, err = rules.LoadRules(rulesPath)
+		if err != nil {
+			log.Fatalf("error loading rules file (%s): %v\n", rulesPath, err)
+		}
+	}
+
+	hostname, _ := os.Hostname()
+
+	// A rule matching on min_window_entropy needs sliding-window tracking enabled to ever fire; if
+	// the user didn't size the window explicitly, pick a default rather than leaving the rule dead.
+	if windowSize <= 0 {
+		for _, r := range ruleSet {
+			if r.MinWindowEntropy > 0 {
+				windowSize = constDefaultWindowSize
+				break
+			}
+		}
+	}
+
+	csvCols := output.CSVColumns{
+		MaxWindowEntropy: windowSize > 0,
+		ELFSections:      elfSections,
+		Truncated:        earlyExitThreshold > 0,
+		Regions:          procMem,
+	}
+
+	opts := scanOptions{
+		elfOnly:            elfOnly,
+		entropyMaxVal:      entropyMaxVal,
+		ruleSet:            ruleSet,
+		csvOutput:          csvOutput,
+		delimChar:          delimChar,
+		showIndicators:     showIndicators,
+		elfSections:        elfSections,
+		writer:             newOutputWriter(jsonOutput, ndjsonOutput, csvOutput, delimChar, csvCols),
+		hostname:           hostname,
+		windowSize:         windowSize,
+		maxFileSize:        maxFileSize,
+		earlyExitThreshold: earlyExitThreshold,
+	}
+
 	if procOnly {
 		// This will do a PID bust of all PID range to help detect hidden PIDs.
 		pidPaths, err := genPIDExePaths()
 		if err != nil {
 			log.Fatalf("error generating PID list: %v\n", err)
 		}
-		for pid := 0; pid < len(pidPaths); pid++ {
+		for i := 0; i < len(pidPaths); i++ {
 			// Only check elf files which should be all these will be anyway.
-			fileInfo, err := checkFilePath(pidPaths[pid], true, entropyMaxVal)
+			fileInfo, err := checkFilePath(pidPaths[i], opts)
 			// anything that is not an error is a valid /proc/*/exe link we could see and process. We will analyze it.
 			if err == nil {
-				if fileInfo.entropy >= entropyMaxVal {
-					printResults(fileInfo, csvOutput, delimChar)
+				reportFile(fileInfo, opts)
+			}
+
+			if procMem {
+				pid := i + constMinPID
+				regions, err := procscan.ScanPID(pid)
+				if err == nil {
+					for _, region := range regions {
+						reportRegion(pid, region, opts)
+					}
 				}
 			}
 		}
+		if err := opts.writer.Close(); err != nil {
+			log.Fatalf("error writing output: %v\n", err)
+		}
 		os.Exit(0)
 	}
 
 	if filePath != "" {
-		fileInfo, err := checkFilePath(filePath, elfOnly, entropyMaxVal)
+		fileInfo, err := checkFilePath(filePath, opts)
 		if err != nil {
 			log.Fatalf("error processing file (%s): %v\n", filePath, err)
 		}
 
-		if fileInfo.entropy >= entropyMaxVal {
-			printResults(fileInfo, csvOutput, delimChar)
-		}
+		reportFile(fileInfo, opts)
 
+		if err := opts.writer.Close(); err != nil {
+			log.Fatalf("error writing output: %v\n", err)
+		}
 		os.Exit(0)
 	}
 
 	if dirPath != "" {
-		var search = func(filePath string, info os.FileInfo, err error) error {
-			if err != nil {
-				log.Fatalf("error walking directory (%s) inside search function: %v\n", filePath, err)
+		if err := scanDirectory(dirPath, opts, workers); err != nil {
+			log.Fatalf("%v\n", err)
+		}
+		if err := opts.writer.Close(); err != nil {
+			log.Fatalf("error writing output: %v\n", err)
+		}
+		os.Exit(0)
+	}
+}
+
+// newOutputWriter picks the OutputWriter matching the output-format flags, in order of
+// specificity: -json, then -ndjson, then -csv, falling back to the original text format. delimChar
+// is used verbatim as the CSV field separator; only its first rune is significant. cols selects
+// which optional column groups CSV output appends; it has no effect on the other writers, which
+// always include whatever was gathered.
+func newOutputWriter(jsonOutput, ndjsonOutput, csvOutput bool, delimChar string, cols output.CSVColumns) output.OutputWriter {
+	switch {
+	case jsonOutput:
+		return output.NewJSONWriter(os.Stdout)
+	case ndjsonOutput:
+		return output.NewNDJSONWriter(os.Stdout)
+	case csvOutput:
+		delim := rune(',')
+		if len(delimChar) > 0 {
+			delim = []rune(delimChar)[0]
+		}
+		return output.NewCSVWriter(os.Stdout, delim, cols)
+	default:
+		return output.NewTextWriter(os.Stdout)
+	}
+}
+
+// walkResult carries one file's outcome from a scanDirectory worker back to its printer goroutine.
+type walkResult struct {
+	path     string
+	fileInfo fileData
+	err      error
+}
+
+// scanDirectory walks dirPath with a bounded pool of workers computing entropy, hashes and rule
+// hits in parallel, feeding a single goroutine that prints results serially so CSV/text output
+// never interleaves. Per-file errors are collected and reported at the end instead of aborting the
+// whole scan, which is what filepath.Walk plus log.Fatalf used to do on the very first bad file.
+func scanDirectory(dirPath string, opts scanOptions, workers int) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	paths := make(chan string, workers*4)
+	results := make(chan walkResult, workers*4)
+
+	var workerWG sync.WaitGroup
+	workerWG.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer workerWG.Done()
+			for p := range paths {
+				fileInfo, err := checkFilePath(p, opts)
+				results <- walkResult{path: p, fileInfo: fileInfo, err: err}
 			}
-			// If info comes back as nil we don't want to read it or we panic.
-			if info != nil {
-				// if not a directory, then check it for a file we want.
-				if !info.IsDir() {
-					// Only check regular files. Checking devices, etc. won't work.
-					if info.Mode().IsRegular() {
-						fileInfo, err := checkFilePath(filePath, elfOnly, entropyMaxVal)
-						if err != nil {
-							log.Fatalf("error processing file (%s): %v\n", filePath, err)
-						}
-
-						if fileInfo.entropy >= entropyMaxVal {
-							printResults(fileInfo, csvOutput, delimChar)
-						}
-					}
-				}
+		}()
+	}
+	go func() {
+		workerWG.Wait()
+		close(results)
+	}()
+
+	showProgress := isTerminal(os.Stderr)
+	printerDone := make(chan []walkResult, 1)
+	go func() {
+		var fileErrs []walkResult
+		var processed, bytesScanned int64
+		for r := range results {
+			processed++
+			if r.err != nil {
+				fileErrs = append(fileErrs, r)
+			} else {
+				bytesScanned += r.fileInfo.size
+				reportFile(r.fileInfo, opts)
 			}
-			return nil
+			if showProgress {
+				printProgress(processed, bytesScanned, r.path)
+			}
+		}
+		if showProgress {
+			fmt.Fprintln(os.Stderr)
 		}
-		err := filepath.Walk(dirPath, search)
+		printerDone <- fileErrs
+	}()
+
+	var walkErrs []walkResult
+	walkErr := filepath.Walk(dirPath, func(walkPath string, info os.FileInfo, err error) error {
 		if err != nil {
-			log.Fatalf("error walking directory (%s): %v\n", dirPath, err)
+			walkErrs = append(walkErrs, walkResult{path: walkPath, err: err})
+			return nil
 		}
-		os.Exit(0)
+		// Only check regular files; devices, sockets, etc. won't work and directories aren't files.
+		if info == nil || info.IsDir() || !info.Mode().IsRegular() {
+			return nil
+		}
+		paths <- walkPath
+		return nil
+	})
+	close(paths)
+
+	fileErrs := <-printerDone
+
+	for _, e := range walkErrs {
+		fmt.Fprintf(os.Stderr, "error walking path (%s): %v\n", e.path, e.err)
+	}
+	for _, e := range fileErrs {
+		fmt.Fprintf(os.Stderr, "error processing file (%s): %v\n", e.path, e.err)
+	}
+
+	if walkErr != nil {
+		return fmt.Errorf("error walking directory (%s): %v", dirPath, walkErr)
+	}
+	return nil
+}
+
+// printProgress writes a single-line, carriage-return-updated progress indicator to stderr.
+func printProgress(processed, bytesScanned int64, currentPath string) {
+	fmt.Fprintf(os.Stderr, "\rscanned %d files, %d bytes: %s\x1b[K", processed, bytesScanned, currentPath)
+}
+
+// isTerminal reports whether f looks like an interactive terminal, used to decide whether to draw
+// a progress indicator.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// reportRegion writes a Record for one -procmem memory region through opts.writer, the same output
+// path reportFile uses for a file, so -json/-ndjson/-csv apply to process memory regions exactly
+// like they do to everything else this tool scans.
+func reportRegion(pid int, region procscan.RegionReport, opts scanOptions) {
+	rec := output.Record{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Hostname:  opts.hostname,
+		Path:      region.Backing,
+		Name:      fmt.Sprintf("pid %d region %#x-%#x", pid, region.Start, region.End),
+		Size:      region.Size,
+		Entropy:   region.Entropy,
+		BytesRead: region.Size,
+		Region: &output.Region{
+			PID:         pid,
+			Start:       region.Start,
+			End:         region.End,
+			Permissions: region.Permissions,
+		},
+	}
+	if region.SHA256 != "" {
+		rec.Hashes = map[string]string{"sha256": region.SHA256}
+	}
+
+	if err := opts.writer.WriteRecord(rec); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing output for pid %d region %#x: %v\n", pid, region.Start, err)
 	}
 }
 
-// Prints results
-func printResults(fileInfo fileData, csvFormat bool, delimChar string) {
-
-	if !csvFormat {
-		fmt.Printf("filename: %s\npath: %s\nentropy: %.2f\nelf: %v\nmd5: %s\nsha1: %s\nsha256: %s\nsha512: %s\n\n",
-			fileInfo.name,
-			fileInfo.path,
-			fileInfo.entropy,
-			fileInfo.elf,
-			fileInfo.hash.md5,
-			fileInfo.hash.sha1,
-			fileInfo.hash.sha256,
-			fileInfo.hash.sha512)
-	} else {
-		fmt.Printf("%s%s%s%s%.2f%s%v%s%s%s%s%s%s%s%s\n",
-			fileInfo.name,
-			delimChar,
-			fileInfo.path,
-			delimChar,
-			fileInfo.entropy,
-			delimChar,
-			fileInfo.elf,
-			delimChar,
-			fileInfo.hash.md5,
-			delimChar,
-			fileInfo.hash.sha1,
-			delimChar,
-			fileInfo.hash.sha256,
-			delimChar,
-			fileInfo.hash.sha512)
+// reportFile writes a Record for fileInfo through opts.writer using the rules engine when a rules
+// file was loaded, or the plain entropy threshold behavior otherwise. A file that doesn't clear the
+// threshold (and fires no rule) produces no Record at all.
+func reportFile(fileInfo fileData, opts scanOptions) {
+	var hits []rules.Rule
+	if len(opts.ruleSet) > 0 {
+		hits = rules.Evaluate(opts.ruleSet, rules.Subject{
+			ELF:              fileInfo.elf,
+			Size:             fileInfo.size,
+			Entropy:          fileInfo.entropy,
+			MaxWindowEntropy: fileInfo.maxWindowEntropy,
+			RandomnessScore:  fileInfo.indicators.RandomnessScore(),
+			MD5:              fileInfo.hash.md5,
+			SHA1:             fileInfo.hash.sha1,
+			SHA256:           fileInfo.hash.sha256,
+			SHA512:           fileInfo.hash.sha512,
+		})
+		if len(hits) == 0 {
+			return
+		}
+	} else if fileInfo.entropy < opts.entropyMaxVal {
+		return
+	}
+
+	if err := opts.writer.WriteRecord(buildRecord(fileInfo, opts, hits)); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing output for file (%s): %v\n", fileInfo.path, err)
+	}
+}
+
+// buildRecord assembles the stable output.Record for fileInfo, including hashes, indicators and
+// ELF section data only when they were actually gathered (entropy threshold/rules met, and the
+// corresponding -indicators/-elf-sections flag was given).
+func buildRecord(fileInfo fileData, opts scanOptions, hits []rules.Rule) output.Record {
+	rec := output.Record{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Hostname:  opts.hostname,
+		Path:      fileInfo.path,
+		Name:      fileInfo.name,
+		Size:      fileInfo.size,
+		Entropy:   fileInfo.entropy,
+		ELF:       fileInfo.elf,
+	}
+
+	if opts.windowSize > 0 {
+		maxWindowEntropy := fileInfo.maxWindowEntropy
+		rec.MaxWindowEntropy = &maxWindowEntropy
+	}
+
+	if fileInfo.truncated {
+		rec.Truncated = true
+		rec.BytesRead = fileInfo.bytesRead
+	}
+
+	if fileInfo.hash.md5 != "" {
+		rec.Hashes = map[string]string{
+			"md5":    fileInfo.hash.md5,
+			"sha1":   fileInfo.hash.sha1,
+			"sha256": fileInfo.hash.sha256,
+			"sha512": fileInfo.hash.sha512,
+		}
+	}
+
+	if opts.showIndicators {
+		ind := fileInfo.indicators
+		rec.Indicators = &output.Indicators{
+			ChiSquare:         ind.ChiSquare,
+			MonteCarloPi:      ind.MonteCarloPi,
+			SerialCorrelation: ind.SerialCorrelation,
+			Mean:              ind.Mean,
+			RandomnessScore:   ind.RandomnessScore(),
+		}
+	}
+
+	if opts.elfSections && fileInfo.elf {
+		for _, sec := range fileInfo.elfSections.Sections {
+			rec.ELFSections = append(rec.ELFSections, output.SectionReport{
+				Name:    sec.Name,
+				Size:    sec.Size,
+				Entropy: sec.Entropy,
+			})
+		}
+		rec.PackerIndicators = fileInfo.elfSections.PackerIndicators
+	}
+
+	for _, hit := range hits {
+		rec.RuleHits = append(rec.RuleHits, output.RuleHit{Name: hit.Name, Severity: hit.Severity})
 	}
+
+	return rec
 }
 
-func checkFilePath(filePath string, elfOnly bool, entropyMaxVal float64) (fileInfo fileData, err error) {
+// checkFilePath gathers entropy, size and (when the entropy threshold is met, or a rules file is
+// loaded since it might match on hash alone) hashes for a single file. When opts.elfSections is set
+// and the file is an ELF, it also gathers per-section entropy and packer indicators.
+func checkFilePath(filePath string, opts scanOptions) (fileInfo fileData, err error) {
 	isElfType, err := fileutils.IsElfType(filePath)
 	if err != nil {
 		return fileInfo, err
@@ -217,44 +523,72 @@ func checkFilePath(filePath string, elfOnly bool, entropyMaxVal float64) (fileIn
 	fileInfo.elf = isElfType
 	fileInfo.entropy = -1
 
-	// If they only want Linux ELFs.
-	if elfOnly && isElfType {
-		entropy, err := fileutils.Entropy(filePath)
-		if err != nil {
-			log.Fatalf("error calculating entropy for file (%s): %v\n", filePath, err)
-		}
-		fileInfo.entropy = entropy
+	// If they only want Linux ELFs and this isn't one, nothing more to do.
+	if opts.elfOnly && !isElfType {
+		return fileInfo, nil
 	}
-	// They want entropy on all files.
-	if !elfOnly {
-		entropy, err := fileutils.Entropy(filePath)
-		if err != nil {
-			log.Fatalf("error calculating entropy for file (%s): %v\n", filePath, err)
-		}
-		fileInfo.entropy = entropy
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return fileInfo, fmt.Errorf("couldn't open path (%s): %v", filePath, err)
 	}
+	defer f.Close()
 
-	if fileInfo.entropy >= entropyMaxVal {
-		md5, err := fileutils.HashMD5(filePath)
-		if err != nil {
-			log.Fatalf("error calculating MD5 hash for file (%s): %v\n", filePath, err)
-		}
-		sha1, err := fileutils.HashSHA1(filePath)
-		if err != nil {
-			log.Fatalf("error calculating SHA1 hash for file (%s): %v\n", filePath, err)
-		}
-		sha256, err := fileutils.HashSHA256(filePath)
-		if err != nil {
-			log.Fatalf("error calculating SHA256 hash for file (%s): %v\n", filePath, err)
+	fStat, err := f.Stat()
+	if err != nil {
+		return fileInfo, err
+	}
+	if !fStat.Mode().IsRegular() {
+		return fileInfo, fmt.Errorf("file (%s) is not a regular file", filePath)
+	}
+	fileInfo.size = fStat.Size()
+
+	// Reject an oversized file up front rather than streaming most of it before EntropyReader's own
+	// cap catches it partway through.
+	if opts.maxFileSize > 0 && fileInfo.size > opts.maxFileSize {
+		return fileInfo, fmt.Errorf("file (%s) size (%d) exceeds max allowed (%d); raise it with -max-size",
+			filePath, fileInfo.size, opts.maxFileSize)
+	}
+
+	// Zero sized file is zero entropy, nothing to hash.
+	if fileInfo.size == 0 {
+		fileInfo.entropy = 0
+		return fileInfo, nil
+	}
+
+	// A single pass over the file computes entropy and all hashes together instead of reading it
+	// once for entropy and again for each hash.
+	er := fileutils.NewEntropyReader()
+	er.SetMaxSize(opts.maxFileSize)
+	if opts.windowSize > 0 {
+		er.EntropyWindow(opts.windowSize)
+	}
+	// Early exit only makes sense when a bare entropy threshold is the only thing deciding whether
+	// the file is reported: a rules file may also match on hash or size, which need the whole file.
+	if opts.earlyExitThreshold > 0 && len(opts.ruleSet) == 0 {
+		er.SetThreshold(opts.earlyExitThreshold)
+	}
+	if _, err := io.Copy(er, f); err != nil {
+		if !errors.Is(err, fileutils.ErrThresholdExceeded) {
+			return fileInfo, fmt.Errorf("error calculating entropy for file (%s): %v", filePath, err)
 		}
-		sha512, err := fileutils.HashSHA512(filePath)
-		if err != nil {
-			log.Fatalf("error calculating SHA512 hash for file (%s): %v\n", filePath, err)
+		// The early-exit threshold stopped the read before EOF: entropy, hashes and indicators below
+		// reflect only the bytes actually read, not fileInfo.size (the full on-disk size set above).
+		fileInfo.truncated = true
+	}
+	fileInfo.bytesRead = er.Size()
+	fileInfo.entropy = er.Entropy()
+	fileInfo.maxWindowEntropy = er.MaxWindowEntropy()
+	fileInfo.indicators = er.Indicators()
+
+	if fileInfo.entropy >= opts.entropyMaxVal || len(opts.ruleSet) > 0 {
+		fileInfo.hash.md5, fileInfo.hash.sha1, fileInfo.hash.sha256, fileInfo.hash.sha512 = er.Hashes()
+	}
+
+	if opts.elfSections && isElfType {
+		if analysis, err := fileutils.AnalyzeELFSections(filePath); err == nil {
+			fileInfo.elfSections = analysis
 		}
-		fileInfo.hash.md5 = md5
-		fileInfo.hash.sha1 = sha1
-		fileInfo.hash.sha256 = sha256
-		fileInfo.hash.sha512 = sha512
 	}
 
 	return fileInfo, nil